@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// commonLogFormat and combinedLogFormat are the two named Apache log
+// formats accepted by -access-log, alongside "off" and a literal custom
+// format string.
+const (
+	commonLogFormat   = `%h %l %u %t "%r" %>s %b`
+	combinedLogFormat = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i" %D`
+)
+
+// accessLogEntry holds the fields of a single logged request, used to
+// render an access log line from an Apache-style format template.
+type accessLogEntry struct {
+	RemoteHost     string
+	RemoteLogname  string
+	RemoteUser     string
+	Time           string
+	RequestLine    string
+	Status         int
+	Bytes          int
+	Referer        string
+	UserAgent      string
+	DurationMicros int64
+}
+
+// resolveAccessLogFormat maps the named -access-log values to their Apache
+// format strings, passing anything else through as a literal custom format.
+func resolveAccessLogFormat(name string) string {
+	switch name {
+	case "common":
+		return commonLogFormat
+	case "combined":
+		return combinedLogFormat
+	default:
+		return name
+	}
+}
+
+// apacheFormatToTemplate translates an Apache log config directive string
+// into the equivalent text/template body.
+func apacheFormatToTemplate(format string) string {
+	replacer := strings.NewReplacer(
+		`%h`, `{{.RemoteHost}}`,
+		`%l`, `{{.RemoteLogname}}`,
+		`%u`, `{{.RemoteUser}}`,
+		`%t`, `{{.Time}}`,
+		`%r`, `{{.RequestLine}}`,
+		`%>s`, `{{.Status}}`,
+		`%b`, `{{.Bytes}}`,
+		`%{Referer}i`, `{{.Referer}}`,
+		`%{User-agent}i`, `{{.UserAgent}}`,
+		`%D`, `{{.DurationMicros}}`,
+	)
+	return replacer.Replace(format)
+}
+
+// newAccessLogTemplate compiles the named or literal -access-log format
+// into a text/template.
+func newAccessLogTemplate(name string) (*template.Template, error) {
+	return template.New("accesslog").Parse(apacheFormatToTemplate(resolveAccessLogFormat(name)))
+}
+
+// renderAccessLogEntry executes tmpl against entry, producing a single
+// access log line.
+func renderAccessLogEntry(tmpl *template.Template, entry accessLogEntry) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, entry); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// statusWriter wraps http.ResponseWriter to capture the status code and
+// byte count written, for the %>s and %b directives.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+// headerOrDash returns r's header value, or "-" if unset, matching
+// Apache's convention for missing fields.
+func headerOrDash(r *http.Request, name string) string {
+	if v := r.Header.Get(name); v != "" {
+		return v
+	}
+	return "-"
+}
+
+// remoteHost returns r.RemoteAddr with the port stripped, or the address
+// verbatim if it isn't a host:port pair.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// accessLogMiddleware wraps next with Apache-style access logging in the
+// format named by accessLog ("off", "common", "combined", or a literal
+// custom format string). "off" returns next unwrapped.
+func accessLogMiddleware(accessLog string, next http.Handler) http.Handler {
+	if accessLog == "" || accessLog == "off" {
+		return next
+	}
+	tmpl, err := newAccessLogTemplate(accessLog)
+	if err != nil {
+		log.Fatalf("invalid -access-log format: %v", err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		entry := accessLogEntry{
+			RemoteHost:     remoteHost(r),
+			RemoteLogname:  "-",
+			RemoteUser:     "-",
+			Time:           start.Format("02/Jan/2006:15:04:05 -0700"),
+			RequestLine:    fmt.Sprintf("%s %s %s", r.Method, r.RequestURI, r.Proto),
+			Status:         sw.status,
+			Bytes:          sw.bytes,
+			Referer:        headerOrDash(r, "Referer"),
+			UserAgent:      headerOrDash(r, "User-Agent"),
+			DurationMicros: time.Since(start).Microseconds(),
+		}
+		line, err := renderAccessLogEntry(tmpl, entry)
+		if err != nil {
+			log.Printf("access log template error: %v", err)
+			return
+		}
+		log.Println(line)
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func cannedAccessLogEntry() accessLogEntry {
+	return accessLogEntry{
+		RemoteHost:     "127.0.0.1",
+		RemoteLogname:  "-",
+		RemoteUser:     "-",
+		Time:           "10/Oct/2023:13:55:36 -0700",
+		RequestLine:    "GET /todos HTTP/1.1",
+		Status:         200,
+		Bytes:          1234,
+		Referer:        "-",
+		UserAgent:      "curl/8.0.1",
+		DurationMicros: 4567,
+	}
+}
+
+func TestRenderAccessLogEntryCommon(t *testing.T) {
+	tmpl, err := newAccessLogTemplate("common")
+	if err != nil {
+		t.Fatalf("newAccessLogTemplate: %v", err)
+	}
+
+	got, err := renderAccessLogEntry(tmpl, cannedAccessLogEntry())
+	if err != nil {
+		t.Fatalf("renderAccessLogEntry: %v", err)
+	}
+
+	want := `127.0.0.1 - - 10/Oct/2023:13:55:36 -0700 "GET /todos HTTP/1.1" 200 1234`
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestRenderAccessLogEntryCombined(t *testing.T) {
+	tmpl, err := newAccessLogTemplate("combined")
+	if err != nil {
+		t.Fatalf("newAccessLogTemplate: %v", err)
+	}
+
+	got, err := renderAccessLogEntry(tmpl, cannedAccessLogEntry())
+	if err != nil {
+		t.Fatalf("renderAccessLogEntry: %v", err)
+	}
+
+	want := `127.0.0.1 - - 10/Oct/2023:13:55:36 -0700 "GET /todos HTTP/1.1" 200 1234 "-" "curl/8.0.1" 4567`
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestRenderAccessLogEntryCustomFormat(t *testing.T) {
+	tmpl, err := newAccessLogTemplate(`%h %>s %D`)
+	if err != nil {
+		t.Fatalf("newAccessLogTemplate: %v", err)
+	}
+
+	got, err := renderAccessLogEntry(tmpl, cannedAccessLogEntry())
+	if err != nil {
+		t.Fatalf("renderAccessLogEntry: %v", err)
+	}
+
+	want := "127.0.0.1 200 4567"
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestAccessLogMiddlewareOffSkipsWrapping(t *testing.T) {
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	handler := accessLogMiddleware("off", next)
+
+	var logBuf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(prev)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if !called {
+		t.Error("next handler was not called")
+	}
+	if logBuf.Len() != 0 {
+		t.Errorf("log output = %q, want none", logBuf.String())
+	}
+}
+
+func TestAccessLogMiddlewareCapturesStatusAndBytes(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+
+	handler := accessLogMiddleware("common", next)
+
+	var logBuf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(prev)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "127.0.0.1:54321"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if w.Body.String() != "hello" {
+		t.Errorf("body = %q, want %q", w.Body.String(), "hello")
+	}
+	if want := `"GET / HTTP/1.1" 201 5`; !strings.Contains(logBuf.String(), want) {
+		t.Errorf("log line = %q, want it to contain %q", logBuf.String(), want)
+	}
+}
+
+func TestHeaderOrDash(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if got := headerOrDash(req, "Referer"); got != "-" {
+		t.Errorf("headerOrDash(missing) = %q, want %q", got, "-")
+	}
+
+	req.Header.Set("Referer", "https://example.com")
+	if got := headerOrDash(req, "Referer"); got != "https://example.com" {
+		t.Errorf("headerOrDash(set) = %q, want %q", got, "https://example.com")
+	}
+}
+
+func TestRemoteHost(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.5:12345"
+
+	if got := remoteHost(req); got != "203.0.113.5" {
+		t.Errorf("remoteHost = %q, want %q", got, "203.0.113.5")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiError is the JSON error envelope returned by the /api/v1 endpoints.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeAPIJSON writes v as a JSON response with the given status code.
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError writes a JSON error envelope with the given status code.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, apiError{Error: message})
+}
+
+// handleAPITodosCollection serves GET (list) and POST (create) on
+// /api/v1/todos.
+func handleAPITodosCollection(w http.ResponseWriter, r *http.Request, userID int64) {
+	switch r.Method {
+	case http.MethodGet:
+		only := parseOnlyFilter(r)
+		list, err := todoRepo.FindList(userID, only)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, list)
+
+	case http.MethodPost:
+		var body struct {
+			Title string `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, "invalid JSON body")
+			return
+		}
+		if body.Title == "" {
+			writeAPIError(w, http.StatusUnprocessableEntity, "title is required")
+			return
+		}
+		t, err := todoRepo.Save(userID, Todo{Title: body.Title})
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeAPIJSON(w, http.StatusCreated, t)
+
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAPITodosItem serves GET, PATCH, and DELETE on /api/v1/todos/{id}.
+func handleAPITodosItem(w http.ResponseWriter, r *http.Request, userID int64) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/v1/todos/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "todo not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		t, err := todoRepo.Find(userID, id)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, "todo not found")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, t)
+
+	case http.MethodPatch:
+		existing, err := todoRepo.Find(userID, id)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, "todo not found")
+			return
+		}
+		var body struct {
+			Title     *string `json:"title"`
+			Completed *bool   `json:"completed"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, "invalid JSON body")
+			return
+		}
+		if body.Title != nil {
+			if *body.Title == "" {
+				writeAPIError(w, http.StatusUnprocessableEntity, "title cannot be empty")
+				return
+			}
+			existing.Title = *body.Title
+		}
+		if body.Completed != nil {
+			existing.Completed = *body.Completed
+		}
+		t, err := todoRepo.Save(userID, existing)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, t)
+
+	case http.MethodDelete:
+		if err := todoRepo.Destroy(userID, id); err != nil {
+			if err == sql.ErrNoRows {
+				writeAPIError(w, http.StatusNotFound, "todo not found")
+				return
+			}
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
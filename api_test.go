@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAPITodosCollection(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		setup      func(userID int64)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "GET empty list",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantBody:   "[]",
+		},
+		{
+			name:       "GET populated list",
+			method:     http.MethodGet,
+			setup:      func(userID int64) { createTodo(userID, "Existing") },
+			wantStatus: http.StatusOK,
+			wantBody:   "Existing",
+		},
+		{
+			name:       "POST creates a todo",
+			method:     http.MethodPost,
+			body:       `{"title":"From API"}`,
+			wantStatus: http.StatusCreated,
+			wantBody:   "From API",
+		},
+		{
+			name:       "POST with empty title is unprocessable",
+			method:     http.MethodPost,
+			body:       `{"title":""}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "POST with malformed JSON is unprocessable",
+			method:     http.MethodPost,
+			body:       `not json`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "PUT is not allowed",
+			method:     http.MethodPut,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			userID := setupTestUser(t, "a@example.com")
+			if tt.setup != nil {
+				tt.setup(userID)
+			}
+
+			var body *strings.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			} else {
+				body = strings.NewReader("")
+			}
+			req := httptest.NewRequest(tt.method, "/api/v1/todos", body)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			handleAPITodosCollection(w, req, userID)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body: %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantBody != "" && !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("Content-Type"); !strings.Contains(got, "application/json") {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+		})
+	}
+}
+
+func TestAPITodosItem(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wrongUser  bool
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "GET existing todo",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantBody:   "Item",
+		},
+		{
+			name:       "GET another user's todo is not found",
+			method:     http.MethodGet,
+			wrongUser:  true,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "PATCH updates title",
+			method:     http.MethodPatch,
+			body:       `{"title":"Updated"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   "Updated",
+		},
+		{
+			name:       "PATCH updates completed",
+			method:     http.MethodPatch,
+			body:       `{"completed":true}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"completed":true`,
+		},
+		{
+			name:       "PATCH with empty title is unprocessable",
+			method:     http.MethodPatch,
+			body:       `{"title":""}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "DELETE removes the todo",
+			method:     http.MethodDelete,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "DELETE another user's todo is not found",
+			method:     http.MethodDelete,
+			wrongUser:  true,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			owner := setupTestUser(t, "owner@example.com")
+			requester := owner
+			if tt.wrongUser {
+				requester = setupTestUser(t, "other@example.com")
+			}
+			id, _ := createTodo(owner, "Item")
+
+			var body *strings.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			} else {
+				body = strings.NewReader("")
+			}
+			target := "/api/v1/todos/" + strconv.FormatInt(id, 10)
+			req := httptest.NewRequest(tt.method, target, body)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			handleAPITodosItem(w, req, requester)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body: %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantBody != "" && !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAPITodosItemNotFoundForBadID(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/todos/not-a-number", nil)
+	w := httptest.NewRecorder()
+
+	handleAPITodosItem(w, req, userID)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAPITodosContentNegotiation(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+	createTodo(userID, "Negotiated")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/todos", nil)
+	w := httptest.NewRecorder()
+
+	handleAPITodosCollection(w, req, userID)
+
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want %q", got, "application/json")
+	}
+
+	var decoded []Todo
+	if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("response body is not valid JSON: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0].Title != "Negotiated" {
+		t.Errorf("decoded = %v, want one todo titled %q", decoded, "Negotiated")
+	}
+}
+
+func TestAPIErrorEnvelope(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/todos", strings.NewReader(`{"title":""}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handleAPITodosCollection(w, req, userID)
+
+	var decoded apiError
+	if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("response body is not a JSON error envelope: %v", err)
+	}
+	if decoded.Error == "" {
+		t.Error("error envelope has empty Error field")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event is a single row of the append-only events table.
+type Event struct {
+	ID      int64           `json:"id"`
+	Ts      time.Time       `json:"ts"`
+	UserID  int64           `json:"user_id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// TodoCreatedPayload is the Payload of a TodoCreated event.
+type TodoCreatedPayload struct {
+	ID     int64  `json:"id"`
+	Title  string `json:"title"`
+	UserID int64  `json:"user_id"`
+}
+
+// TodoToggledPayload is the Payload of a TodoToggled event.
+type TodoToggledPayload struct {
+	ID int64 `json:"id"`
+}
+
+// TodoUpdatedPayload is the Payload of a TodoUpdated event, recording the
+// full post-update state of the todo's editable fields.
+type TodoUpdatedPayload struct {
+	ID        int64  `json:"id"`
+	Title     string `json:"title"`
+	Completed bool   `json:"completed"`
+}
+
+// TodoDeletedPayload is the Payload of a TodoDeleted event.
+type TodoDeletedPayload struct {
+	ID int64 `json:"id"`
+}
+
+// EventStore is an append-only log of domain events backed by the events
+// table. It is the system of record; the in-memory todoProjection is
+// rebuilt from it on startup.
+type EventStore struct {
+	db *sql.DB
+}
+
+// NewEventStore wraps db as an EventStore.
+func NewEventStore(db *sql.DB) *EventStore {
+	return &EventStore{db: db}
+}
+
+// Append marshals payload to JSON and appends it to the event log as an
+// event of the given type, owned by userID.
+func (es *EventStore) Append(userID int64, eventType string, payload interface{}) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+	res, err := es.db.Exec("INSERT INTO events (ts, user_id, type, payload) VALUES (NOW(), $1, $2, $3)", userID, eventType, data)
+	if err != nil {
+		return Event{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{ID: id, UserID: userID, Type: eventType, Payload: data}, nil
+}
+
+// Replay calls fn with every event in the log, in append order.
+func (es *EventStore) Replay(fn func(Event)) error {
+	rows, err := es.db.Query("SELECT id, ts, user_id, type, payload FROM events ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.Ts, &e.UserID, &e.Type, &e.Payload); err != nil {
+			return err
+		}
+		fn(e)
+	}
+	return rows.Err()
+}
+
+// Since returns every event owned by userID appended after id, in append
+// order, for the /events tail endpoint.
+func (es *EventStore) Since(userID, id int64) ([]Event, error) {
+	rows, err := es.db.Query("SELECT id, ts, user_id, type, payload FROM events WHERE user_id = $1 AND id > $2 ORDER BY id", userID, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.Ts, &e.UserID, &e.Type, &e.Payload); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// todoProjection is the in-memory read model for todos, kept up to date by
+// applying events as they're appended and rebuilt by replaying the log on
+// startup.
+type todoProjection struct {
+	mu     sync.RWMutex
+	todos  map[int64]Todo
+	nextID int64
+}
+
+func newTodoProjection() *todoProjection {
+	return &todoProjection{todos: make(map[int64]Todo), nextID: 1}
+}
+
+// allocateID hands out the next todo id, so created-but-not-yet-replayed
+// todos don't collide with ids restored from the log.
+func (p *todoProjection) allocateID() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := p.nextID
+	p.nextID++
+	return id
+}
+
+func (p *todoProjection) apply(t Todo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.todos[t.ID] = t
+	if t.ID >= p.nextID {
+		p.nextID = t.ID + 1
+	}
+}
+
+func (p *todoProjection) toggle(id int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.todos[id]
+	if !ok {
+		return
+	}
+	t.Completed = !t.Completed
+	p.todos[id] = t
+}
+
+func (p *todoProjection) delete(id int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.todos, id)
+}
+
+func (p *todoProjection) get(id int64) (Todo, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	t, ok := p.todos[id]
+	return t, ok
+}
+
+// list returns the todos owned by userID matching only ("all", "active",
+// or "completed"), ordered by id.
+func (p *todoProjection) list(userID int64, only string) []Todo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	result := []Todo{}
+	for _, t := range p.todos {
+		if t.UserID != userID {
+			continue
+		}
+		if only == "active" && t.Completed {
+			continue
+		}
+		if only == "completed" && !t.Completed {
+			continue
+		}
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func (p *todoProjection) countIncomplete(userID int64) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	n := 0
+	for _, t := range p.todos {
+		if t.UserID == userID && !t.Completed {
+			n++
+		}
+	}
+	return n
+}
+
+// rebuildProjection replays every event in store into a fresh projection,
+// used on startup to restore the in-memory read model from the log.
+func rebuildProjection(store *EventStore) (*todoProjection, error) {
+	p := newTodoProjection()
+	err := store.Replay(func(e Event) {
+		switch e.Type {
+		case "TodoCreated":
+			var payload TodoCreatedPayload
+			if err := json.Unmarshal(e.Payload, &payload); err != nil {
+				return
+			}
+			p.apply(Todo{ID: payload.ID, Title: payload.Title, UserID: payload.UserID})
+		case "TodoToggled":
+			var payload TodoToggledPayload
+			if err := json.Unmarshal(e.Payload, &payload); err != nil {
+				return
+			}
+			p.toggle(payload.ID)
+		case "TodoUpdated":
+			var payload TodoUpdatedPayload
+			if err := json.Unmarshal(e.Payload, &payload); err != nil {
+				return
+			}
+			t, ok := p.get(payload.ID)
+			if !ok {
+				return
+			}
+			t.Title = payload.Title
+			t.Completed = payload.Completed
+			p.apply(t)
+		case "TodoDeleted":
+			var payload TodoDeletedPayload
+			if err := json.Unmarshal(e.Payload, &payload); err != nil {
+				return
+			}
+			p.delete(payload.ID)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
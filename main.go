@@ -1,7 +1,10 @@
 package main
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"html"
@@ -16,130 +19,297 @@ import (
 )
 
 var db *sql.DB
+var events *EventStore
+var todos *todoProjection
+var todoRepo TodoRepository
+
+// Todo is the read-model projection of a single todo, owned by a single
+// user. The json tags double as the wire format for the /api/v1/todos
+// endpoints, so they must match the field names accepted by the PATCH/POST
+// request bodies in api.go.
+type Todo struct {
+	ID        int64  `json:"id"`
+	Title     string `json:"title"`
+	Completed bool   `json:"completed"`
+	UserID    int64  `json:"user_id"`
+}
+
+// User is a single row of the users table.
+type User struct {
+	ID    int64
+	Email string
+	Token string
+}
+
+// isHXRequest reports whether r was issued by htmx, so the handler can
+// return a fragment instead of redirecting the whole page.
+func isHXRequest(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+// renderTodoRow renders a single todo as the `<div class="box">` fragment
+// shared by the full list and the htmx partial responses.
+func renderTodoRow(t Todo) string {
+	checked := ""
+	class := ""
+	if t.Completed {
+		checked = "checked"
+		class = "completed"
+	}
+	return fmt.Sprintf(`<div class="box" id="todo-%d" style="display:flex; align-items:center; gap:0.75rem; padding:0.75rem;">
+  <form hx-post="/toggle" hx-target="#todo-%d" hx-swap="outerHTML" style="margin:0;">
+    <input type="hidden" name="id" value="%d">
+    <input type="checkbox" %s onchange="this.form.requestSubmit()" style="width:1.2em;height:1.2em;">
+  </form>
+  <span class="%s" style="flex:1;">%s</span>
+  <form hx-post="/delete" hx-target="#todo-%d" hx-swap="outerHTML" style="margin:0;">
+    <input type="hidden" name="id" value="%d">
+    <button class="button is-small is-danger is-outlined" type="submit">Delete</button>
+  </form>
+</div>`, t.ID, t.ID, t.ID, checked, class, html.EscapeString(t.Title), t.ID, t.ID)
+}
 
 func initDB(dbPath string) (*sql.DB, error) {
 	database, err := sql.Open("pglike", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("opening database: %w", err)
 	}
-	_, err = database.Exec(`CREATE TABLE IF NOT EXISTS todos (
+	_, err = database.Exec(`CREATE TABLE IF NOT EXISTS users (
+		id SERIAL PRIMARY KEY,
+		email VARCHAR(320) UNIQUE NOT NULL,
+		token VARCHAR(64) NOT NULL
+	)`)
+	if err != nil {
+		database.Close()
+		return nil, fmt.Errorf("creating users table: %w", err)
+	}
+	_, err = database.Exec(`CREATE TABLE IF NOT EXISTS events (
 		id SERIAL PRIMARY KEY,
-		title VARCHAR(500) NOT NULL,
-		completed BOOLEAN DEFAULT FALSE,
-		created_at TIMESTAMP DEFAULT NOW()
+		ts TIMESTAMP DEFAULT NOW(),
+		user_id BIGINT NOT NULL,
+		type TEXT NOT NULL,
+		payload JSONB NOT NULL
 	)`)
 	if err != nil {
 		database.Close()
-		return nil, fmt.Errorf("creating table: %w", err)
+		return nil, fmt.Errorf("creating events table: %w", err)
 	}
 	return database, nil
 }
 
-func listTodos() {
-	rows, err := db.Query("SELECT id, title, completed FROM todos ORDER BY id")
+// generateToken returns a random 32-byte session token, hex-encoded.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// registerUser creates a new user with a freshly generated token.
+func registerUser(email string) (User, error) {
+	token, err := generateToken()
 	if err != nil {
-		lofigui.Printf("Error listing todos: %v", err)
-		return
+		return User{}, err
 	}
-	defer rows.Close()
+	res, err := db.Exec("INSERT INTO users (email, token) VALUES ($1, $2)", email, token)
+	if err != nil {
+		return User{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	return User{ID: id, Email: email, Token: token}, nil
+}
+
+// userByEmailAndToken looks up a user by the credentials submitted at login.
+func userByEmailAndToken(email, token string) (User, error) {
+	var u User
+	err := db.QueryRow("SELECT id, email, token FROM users WHERE email = $1 AND token = $2", email, token).Scan(&u.ID, &u.Email, &u.Token)
+	return u, err
+}
+
+// userByToken looks up a user by their session token.
+func userByToken(token string) (User, error) {
+	var u User
+	err := db.QueryRow("SELECT id, email, token FROM users WHERE token = $1", token).Scan(&u.ID, &u.Email, &u.Token)
+	return u, err
+}
 
-	var todos []struct {
-		ID        int64
-		Title     string
-		Completed int64
+// tokenFromRequest reads the session token from the session cookie, or
+// returns "" if no session cookie is present.
+func tokenFromRequest(r *http.Request) string {
+	c, err := r.Cookie("session")
+	if err != nil {
+		return ""
 	}
-	for rows.Next() {
-		var t struct {
-			ID        int64
-			Title     string
-			Completed int64
+	return c.Value
+}
+
+// requireAuth wraps a handler so that it only runs for requests carrying a
+// valid session cookie, responding 401 otherwise. The authenticated user's
+// id is passed through to next.
+func requireAuth(next func(w http.ResponseWriter, r *http.Request, userID int64)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFromRequest(r)
+		if token == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
 		}
-		if err := rows.Scan(&t.ID, &t.Title, &t.Completed); err != nil {
-			lofigui.Printf("Error scanning todo: %v", err)
+		u, err := userByToken(token)
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		todos = append(todos, t)
+		next(w, r, u.ID)
 	}
+}
 
-	if len(todos) == 0 {
+// listTodos renders the todos owned by userID matching only, which is one
+// of "all", "active", or "completed".
+func listTodos(userID int64, only string) {
+	rows, err := todoRepo.FindList(userID, only)
+	if err != nil {
+		lofigui.Printf("Error listing todos: %v", err)
+		return
+	}
+	if len(rows) == 0 {
 		lofigui.Markdown("*No todos yet. Add one below!*")
 		return
 	}
+	for _, t := range rows {
+		lofigui.HTML(renderTodoRow(t))
+	}
+}
 
-	for _, t := range todos {
-		checked := ""
-		class := ""
-		if t.Completed == 1 {
-			checked = "checked"
-			class = "completed"
-		}
-		lofigui.HTML(fmt.Sprintf(`<div class="box" style="display:flex; align-items:center; gap:0.75rem; padding:0.75rem;">
-  <form action="/toggle" method="post" style="margin:0;">
-    <input type="hidden" name="id" value="%d">
-    <input type="checkbox" %s onchange="this.form.submit()" style="width:1.2em;height:1.2em;">
-  </form>
-  <span class="%s" style="flex:1;">%s</span>
-  <form action="/delete" method="post" style="margin:0;">
-    <input type="hidden" name="id" value="%d">
-    <button class="button is-small is-danger is-outlined" type="submit">Delete</button>
-  </form>
-</div>`, t.ID, checked, class, html.EscapeString(t.Title), t.ID))
+// countIncompleteTodos returns the number of todos owned by userID that are
+// not yet completed, for the "N items left" counter.
+func countIncompleteTodos(userID int64) (int, error) {
+	rows, err := todoRepo.FindList(userID, "active")
+	if err != nil {
+		return 0, err
 	}
+	return len(rows), nil
 }
 
-func createTodo(title string) error {
-	_, err := db.Exec("INSERT INTO todos (title) VALUES ($1)", title)
-	return err
+func createTodo(userID int64, title string) (int64, error) {
+	t, err := todoRepo.Save(userID, Todo{Title: title})
+	if err != nil {
+		return 0, err
+	}
+	return t.ID, nil
 }
 
-func toggleTodo(id int64) error {
-	_, err := db.Exec("UPDATE todos SET completed = NOT completed WHERE id = $1", id)
-	return err
+func getTodo(userID, id int64) (Todo, error) {
+	return todoRepo.Find(userID, id)
 }
 
-func deleteTodo(id int64) error {
-	_, err := db.Exec("DELETE FROM todos WHERE id = $1", id)
-	return err
+func toggleTodo(userID, id int64) error {
+	return todoRepo.Toggle(userID, id)
 }
 
-func handleIndex(ctrl *lofigui.Controller) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func deleteTodo(userID, id int64) error {
+	return todoRepo.Destroy(userID, id)
+}
+
+// parseOnlyFilter validates the "only" query parameter, defaulting to "all"
+// for anything other than "active" or "completed".
+func parseOnlyFilter(r *http.Request) string {
+	switch only := r.URL.Query().Get("only"); only {
+	case "active", "completed":
+		return only
+	default:
+		return "all"
+	}
+}
+
+// renderFilterBar renders the active/completed/all filter tabs plus the
+// "N items left" counter shown above the create form.
+func renderFilterBar(only string, remaining int) string {
+	tab := func(name, label string) string {
+		class := "filter-tab"
+		if name == only {
+			class += " is-active"
+		}
+		href := "/"
+		if name != "all" {
+			href = "/?only=" + name
+		}
+		return fmt.Sprintf(`<a class="%s" href="%s" hx-get="%s" hx-target="#todo-app" hx-swap="outerHTML">%s</a>`, class, href, href, label)
+	}
+	return fmt.Sprintf(`<div class="filters" style="display:flex; gap:1rem; align-items:center; padding:0.5rem 0;">
+  <span>%d items left</span>
+  %s %s %s
+</div>`, remaining, tab("all", "All"), tab("active", "Active"), tab("completed", "Completed"))
+}
+
+func handleIndex(ctrl *lofigui.Controller) func(w http.ResponseWriter, r *http.Request, userID int64) {
+	return func(w http.ResponseWriter, r *http.Request, userID int64) {
 		if r.URL.Path != "/" {
 			http.NotFound(w, r)
 			return
 		}
+		only := parseOnlyFilter(r)
+
 		lofigui.Reset()
-		listTodos()
+		lofigui.HTML(`<div id="todo-app">`)
+		lofigui.HTML(`<div id="todo-list">`)
+		listTodos(userID, only)
+		lofigui.HTML(`</div>`)
+
+		remaining, err := countIncompleteTodos(userID)
+		if err != nil {
+			lofigui.Printf("Error counting todos: %v", err)
+		}
+		lofigui.HTML(renderFilterBar(only, remaining))
 
 		lofigui.HTML(`<hr>
-<form action="/create" method="post" style="display:flex; gap:0.5rem;">
+<form hx-post="/create" hx-target="#todo-list" hx-swap="beforeend" action="/create" method="post" style="display:flex; gap:0.5rem;">
   <input class="input" type="text" name="title" placeholder="What needs to be done?" required>
   <button class="button is-primary" type="submit">Add</button>
 </form>`)
+		lofigui.HTML(`</div>`)
+
+		if isHXRequest(r) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, lofigui.Buffer())
+			return
+		}
 
 		context := ctrl.StateDict(r)
 		context["content"] = lofigui.Buffer()
+		context["filter"] = only
+		context["itemsLeft"] = remaining
 		ctrl.RenderTemplate(w, context)
 	}
 }
 
-func handleCreate(w http.ResponseWriter, r *http.Request) {
+func handleCreate(w http.ResponseWriter, r *http.Request, userID int64) {
 	if r.Method != http.MethodPost {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 	r.ParseForm()
 	title := r.FormValue("title")
-	if title != "" {
-		if err := createTodo(title); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	if title == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	id, err := createTodo(userID, title)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isHXRequest(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, renderTodoRow(Todo{ID: id, Title: title}))
+		return
 	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func handleToggle(w http.ResponseWriter, r *http.Request) {
+func handleToggle(w http.ResponseWriter, r *http.Request, userID int64) {
 	if r.Method != http.MethodPost {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -150,14 +320,28 @@ func handleToggle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	if err := toggleTodo(id); err != nil {
+	if err := toggleTodo(userID, id); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if isHXRequest(r) {
+		t, err := getTodo(userID, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, renderTodoRow(t))
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func handleDelete(w http.ResponseWriter, r *http.Request) {
+func handleDelete(w http.ResponseWriter, r *http.Request, userID int64) {
 	if r.Method != http.MethodPost {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -168,16 +352,95 @@ func handleDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	if err := deleteTodo(id); err != nil {
+	if err := deleteTodo(userID, id); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isHXRequest(r) {
+		w.Header().Set("HX-Trigger", "todoDeleted")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	r.ParseForm()
+	email := r.FormValue("email")
+	if email == "" {
+		http.Error(w, "email required", http.StatusBadRequest)
+		return
+	}
+	u, err := registerUser(email)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	fmt.Fprintf(w, "registered %s; token: %s\n", u.Email, u.Token)
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	r.ParseForm()
+	email := r.FormValue("email")
+	token := r.FormValue("token")
+	u, err := userByEmailAndToken(email, token)
+	if err != nil {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session",
+		Value:    u.Token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleEvents tails the event log: GET /events?since=<id> returns every
+// event owned by the authenticated user appended after id as a JSON
+// array, for external subscribers.
+func handleEvents(w http.ResponseWriter, r *http.Request, userID int64) {
+	since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	newEvents, err := events.Since(userID, since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newEvents); err != nil {
+		lofigui.Printf("Error encoding events: %v", err)
+	}
+}
+
 func main() {
 	port := flag.Int("port", 9004, "HTTP server port")
 	dbDir := flag.String("db-dir", ".", "directory for the database file")
+	accessLog := flag.String("access-log", "off", "access log format: off|common|combined|<custom format>")
 	flag.Parse()
 
 	if envPort := os.Getenv("PGLIKE_TODO_PORT"); envPort != "" {
@@ -197,6 +460,13 @@ func main() {
 	}
 	defer db.Close()
 
+	events = NewEventStore(db)
+	todos, err = rebuildProjection(events)
+	if err != nil {
+		log.Fatalf("Failed to rebuild todos projection: %v", err)
+	}
+	todoRepo = NewTodoRepository(events, todos)
+
 	ctrl, err := lofigui.NewController(lofigui.ControllerConfig{
 		Name:         "Todo List",
 		TemplatePath: "templates/todo.html",
@@ -205,13 +475,19 @@ func main() {
 		log.Fatalf("Failed to create controller: %v", err)
 	}
 
-	http.HandleFunc("/", handleIndex(ctrl))
-	http.HandleFunc("/create", handleCreate)
-	http.HandleFunc("/toggle", handleToggle)
-	http.HandleFunc("/delete", handleDelete)
+	http.HandleFunc("/", requireAuth(handleIndex(ctrl)))
+	http.HandleFunc("/create", requireAuth(handleCreate))
+	http.HandleFunc("/toggle", requireAuth(handleToggle))
+	http.HandleFunc("/delete", requireAuth(handleDelete))
+	http.HandleFunc("/register", handleRegister)
+	http.HandleFunc("/login", handleLogin)
+	http.HandleFunc("/logout", handleLogout)
+	http.HandleFunc("/events", requireAuth(handleEvents))
+	http.HandleFunc("/api/v1/todos", requireAuth(handleAPITodosCollection))
+	http.HandleFunc("/api/v1/todos/", requireAuth(handleAPITodosItem))
 	http.HandleFunc("/favicon.ico", lofigui.ServeFavicon)
 
 	addr := fmt.Sprintf(":%d", *port)
 	log.Printf("Starting todo server on http://localhost%s", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	log.Fatal(http.ListenAndServe(addr, accessLogMiddleware(*accessLog, http.DefaultServeMux)))
 }
@@ -5,10 +5,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
 	"testing"
 
 	_ "github.com/drummonds/go-postgres"
+	"github.com/drummonds/lofigui"
 )
 
 func setupTestDB(t *testing.T) {
@@ -18,16 +20,39 @@ func setupTestDB(t *testing.T) {
 	if err != nil {
 		t.Fatalf("opening test db: %v", err)
 	}
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS todos (
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS users (
 		id SERIAL PRIMARY KEY,
-		title VARCHAR(500) NOT NULL,
-		completed BOOLEAN DEFAULT FALSE,
-		created_at TIMESTAMP DEFAULT NOW()
+		email VARCHAR(320) UNIQUE NOT NULL,
+		token VARCHAR(64) NOT NULL
 	)`)
 	if err != nil {
-		t.Fatalf("creating table: %v", err)
+		t.Fatalf("creating users table: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS events (
+		id SERIAL PRIMARY KEY,
+		ts TIMESTAMP DEFAULT NOW(),
+		user_id BIGINT NOT NULL,
+		type TEXT NOT NULL,
+		payload JSONB NOT NULL
+	)`)
+	if err != nil {
+		t.Fatalf("creating events table: %v", err)
 	}
 	t.Cleanup(func() { db.Close() })
+
+	events = NewEventStore(db)
+	todos = newTodoProjection()
+	todoRepo = NewTodoRepository(events, todos)
+}
+
+// setupTestUser registers a user in the test db and returns its id.
+func setupTestUser(t *testing.T, email string) int64 {
+	t.Helper()
+	u, err := registerUser(email)
+	if err != nil {
+		t.Fatalf("registerUser: %v", err)
+	}
+	return u.ID
 }
 
 func TestInitDB(t *testing.T) {
@@ -37,14 +62,13 @@ func TestInitDB(t *testing.T) {
 	}
 	defer database.Close()
 
-	// Verify the table exists by inserting and querying
-	_, err = database.Exec("INSERT INTO todos (title) VALUES ($1)", "test")
+	_, err = database.Exec("INSERT INTO events (user_id, type, payload) VALUES ($1, $2, $3)", 1, "TodoCreated", `{"id":1,"title":"test","user_id":1}`)
 	if err != nil {
-		t.Fatalf("insert: %v", err)
+		t.Fatalf("insert event: %v", err)
 	}
 
 	var count int
-	err = database.QueryRow("SELECT count(*) FROM todos").Scan(&count)
+	err = database.QueryRow("SELECT count(*) FROM events").Scan(&count)
 	if err != nil {
 		t.Fatalf("count: %v", err)
 	}
@@ -55,182 +79,253 @@ func TestInitDB(t *testing.T) {
 
 func TestCreateTodo(t *testing.T) {
 	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
 
-	err := createTodo("Buy groceries")
+	id, err := createTodo(userID, "Buy groceries")
 	if err != nil {
 		t.Fatalf("createTodo: %v", err)
 	}
 
-	var title string
-	var completed int64
-	err = db.QueryRow("SELECT title, completed FROM todos WHERE id = 1").Scan(&title, &completed)
-	if err != nil {
-		t.Fatalf("query: %v", err)
+	got, ok := todos.get(id)
+	if !ok {
+		t.Fatalf("todo %d not found in projection", id)
 	}
-	if title != "Buy groceries" {
-		t.Errorf("title = %q, want %q", title, "Buy groceries")
+	if got.Title != "Buy groceries" {
+		t.Errorf("title = %q, want %q", got.Title, "Buy groceries")
 	}
-	if completed != 0 {
-		t.Errorf("completed = %d, want 0", completed)
+	if got.Completed {
+		t.Errorf("completed = %v, want false", got.Completed)
+	}
+
+	var eventCount int
+	db.QueryRow("SELECT count(*) FROM events WHERE type = $1", "TodoCreated").Scan(&eventCount)
+	if eventCount != 1 {
+		t.Errorf("TodoCreated events = %d, want 1", eventCount)
 	}
 }
 
 func TestToggleTodo(t *testing.T) {
 	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
 
-	createTodo("Test toggle")
+	id, _ := createTodo(userID, "Test toggle")
 
 	// Toggle on
-	err := toggleTodo(1)
-	if err != nil {
+	if err := toggleTodo(userID, id); err != nil {
 		t.Fatalf("toggleTodo: %v", err)
 	}
-
-	var completed int64
-	db.QueryRow("SELECT completed FROM todos WHERE id = 1").Scan(&completed)
-	if completed != 1 {
-		t.Errorf("after first toggle: completed = %d, want 1", completed)
+	got, _ := todos.get(id)
+	if !got.Completed {
+		t.Errorf("after first toggle: completed = %v, want true", got.Completed)
 	}
 
 	// Toggle off
-	err = toggleTodo(1)
-	if err != nil {
+	if err := toggleTodo(userID, id); err != nil {
 		t.Fatalf("toggleTodo: %v", err)
 	}
+	got, _ = todos.get(id)
+	if got.Completed {
+		t.Errorf("after second toggle: completed = %v, want false", got.Completed)
+	}
 
-	db.QueryRow("SELECT completed FROM todos WHERE id = 1").Scan(&completed)
-	if completed != 0 {
-		t.Errorf("after second toggle: completed = %d, want 0", completed)
+	var eventCount int
+	db.QueryRow("SELECT count(*) FROM events WHERE type = $1", "TodoToggled").Scan(&eventCount)
+	if eventCount != 2 {
+		t.Errorf("TodoToggled events = %d, want 2", eventCount)
 	}
 }
 
 func TestDeleteTodo(t *testing.T) {
 	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
 
-	createTodo("To delete")
+	id, _ := createTodo(userID, "To delete")
 
-	err := deleteTodo(1)
-	if err != nil {
+	if err := deleteTodo(userID, id); err != nil {
 		t.Fatalf("deleteTodo: %v", err)
 	}
 
-	var count int
-	db.QueryRow("SELECT count(*) FROM todos").Scan(&count)
-	if count != 0 {
-		t.Errorf("count after delete = %d, want 0", count)
+	if _, ok := todos.get(id); ok {
+		t.Errorf("todo %d still present after delete", id)
 	}
 }
 
 func TestMultipleTodos(t *testing.T) {
 	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
 
-	createTodo("First")
-	createTodo("Second")
-	createTodo("Third")
+	createTodo(userID, "First")
+	secondID, _ := createTodo(userID, "Second")
+	createTodo(userID, "Third")
 
-	var count int
-	db.QueryRow("SELECT count(*) FROM todos").Scan(&count)
-	if count != 3 {
-		t.Errorf("count = %d, want 3", count)
+	all := todos.list(userID, "all")
+	if len(all) != 3 {
+		t.Errorf("len(all) = %d, want 3", len(all))
 	}
 
-	// Delete the middle one
-	deleteTodo(2)
-	db.QueryRow("SELECT count(*) FROM todos").Scan(&count)
-	if count != 2 {
-		t.Errorf("count after delete = %d, want 2", count)
+	deleteTodo(userID, secondID)
+
+	all = todos.list(userID, "all")
+	if len(all) != 2 {
+		t.Errorf("len(all) after delete = %d, want 2", len(all))
+	}
+	if all[0].Title != "First" || all[1].Title != "Third" {
+		t.Errorf("titles = [%q %q], want [First Third]", all[0].Title, all[1].Title)
+	}
+}
+
+func TestToggleTodoWrongUser(t *testing.T) {
+	setupTestDB(t)
+	owner := setupTestUser(t, "owner@example.com")
+	other := setupTestUser(t, "other@example.com")
+
+	id, _ := createTodo(owner, "Owner's todo")
+
+	if err := toggleTodo(other, id); err != sql.ErrNoRows {
+		t.Errorf("toggleTodo by non-owner: err = %v, want sql.ErrNoRows", err)
+	}
+
+	got, _ := todos.get(id)
+	if got.Completed {
+		t.Errorf("completed = %v, want false (unaffected)", got.Completed)
+	}
+}
+
+func TestDeleteTodoWrongUser(t *testing.T) {
+	setupTestDB(t)
+	owner := setupTestUser(t, "owner@example.com")
+	other := setupTestUser(t, "other@example.com")
+
+	id, _ := createTodo(owner, "Owner's todo")
+
+	if err := deleteTodo(other, id); err != sql.ErrNoRows {
+		t.Errorf("deleteTodo by non-owner: err = %v, want sql.ErrNoRows", err)
 	}
 
-	// Remaining should be First and Third
-	rows, err := db.Query("SELECT title FROM todos ORDER BY id")
+	if _, ok := todos.get(id); !ok {
+		t.Errorf("todo %d should survive a non-owner's delete", id)
+	}
+}
+
+func TestRebuildProjection(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+
+	id, _ := createTodo(userID, "Survives a restart")
+	toggleTodo(userID, id)
+
+	rebuilt, err := rebuildProjection(events)
 	if err != nil {
-		t.Fatalf("query: %v", err)
+		t.Fatalf("rebuildProjection: %v", err)
+	}
+
+	got, ok := rebuilt.get(id)
+	if !ok {
+		t.Fatalf("todo %d missing after replay", id)
 	}
-	defer rows.Close()
+	if got.Title != "Survives a restart" {
+		t.Errorf("title = %q, want %q", got.Title, "Survives a restart")
+	}
+	if !got.Completed {
+		t.Errorf("completed = %v, want true", got.Completed)
+	}
+}
 
-	var titles []string
-	for rows.Next() {
-		var title string
-		rows.Scan(&title)
-		titles = append(titles, title)
+// authedRequest builds a request carrying the session cookie for userID.
+func authedRequest(t *testing.T, method, target string, body *strings.Reader, userID int64) *http.Request {
+	t.Helper()
+	u, err := userByEmailAndTokenForID(userID)
+	if err != nil {
+		t.Fatalf("looking up user: %v", err)
 	}
-	if len(titles) != 2 || titles[0] != "First" || titles[1] != "Third" {
-		t.Errorf("titles = %v, want [First Third]", titles)
+	var req *http.Request
+	if body != nil {
+		req = httptest.NewRequest(method, target, body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
 	}
+	req.AddCookie(&http.Cookie{Name: "session", Value: u.Token})
+	return req
+}
+
+// userByEmailAndTokenForID is a test-only lookup helper, since tests only
+// have the id handed back from setupTestUser.
+func userByEmailAndTokenForID(id int64) (User, error) {
+	var u User
+	err := db.QueryRow("SELECT id, email, token FROM users WHERE id = $1", id).Scan(&u.ID, &u.Email, &u.Token)
+	return u, err
 }
 
 func TestHandleCreateEndpoint(t *testing.T) {
 	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
 
 	form := url.Values{"title": {"Test from HTTP"}}
-	req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(form.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req := authedRequest(t, http.MethodPost, "/create", strings.NewReader(form.Encode()), userID)
 	w := httptest.NewRecorder()
 
-	handleCreate(w, req)
+	handleCreate(w, req, userID)
 
 	if w.Code != http.StatusSeeOther {
 		t.Errorf("status = %d, want %d", w.Code, http.StatusSeeOther)
 	}
 
-	var title string
-	db.QueryRow("SELECT title FROM todos WHERE id = 1").Scan(&title)
-	if title != "Test from HTTP" {
-		t.Errorf("title = %q, want %q", title, "Test from HTTP")
+	all := todos.list(userID, "all")
+	if len(all) != 1 || all[0].Title != "Test from HTTP" {
+		t.Errorf("todos = %v, want one titled %q", all, "Test from HTTP")
 	}
 }
 
 func TestHandleToggleEndpoint(t *testing.T) {
 	setupTestDB(t)
-	createTodo("Toggle me")
+	userID := setupTestUser(t, "a@example.com")
+	id, _ := createTodo(userID, "Toggle me")
 
-	form := url.Values{"id": {"1"}}
-	req := httptest.NewRequest(http.MethodPost, "/toggle", strings.NewReader(form.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	form := url.Values{"id": {strconv.FormatInt(id, 10)}}
+	req := authedRequest(t, http.MethodPost, "/toggle", strings.NewReader(form.Encode()), userID)
 	w := httptest.NewRecorder()
 
-	handleToggle(w, req)
+	handleToggle(w, req, userID)
 
 	if w.Code != http.StatusSeeOther {
 		t.Errorf("status = %d, want %d", w.Code, http.StatusSeeOther)
 	}
 
-	var completed int64
-	db.QueryRow("SELECT completed FROM todos WHERE id = 1").Scan(&completed)
-	if completed != 1 {
-		t.Errorf("completed = %d, want 1", completed)
+	got, _ := todos.get(id)
+	if !got.Completed {
+		t.Errorf("completed = %v, want true", got.Completed)
 	}
 }
 
 func TestHandleDeleteEndpoint(t *testing.T) {
 	setupTestDB(t)
-	createTodo("Delete me")
+	userID := setupTestUser(t, "a@example.com")
+	id, _ := createTodo(userID, "Delete me")
 
-	form := url.Values{"id": {"1"}}
-	req := httptest.NewRequest(http.MethodPost, "/delete", strings.NewReader(form.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	form := url.Values{"id": {strconv.FormatInt(id, 10)}}
+	req := authedRequest(t, http.MethodPost, "/delete", strings.NewReader(form.Encode()), userID)
 	w := httptest.NewRecorder()
 
-	handleDelete(w, req)
+	handleDelete(w, req, userID)
 
 	if w.Code != http.StatusSeeOther {
 		t.Errorf("status = %d, want %d", w.Code, http.StatusSeeOther)
 	}
 
-	var count int
-	db.QueryRow("SELECT count(*) FROM todos").Scan(&count)
-	if count != 0 {
-		t.Errorf("count = %d, want 0", count)
+	if _, ok := todos.get(id); ok {
+		t.Errorf("todo %d still present after delete", id)
 	}
 }
 
 func TestHandleCreateGetRedirects(t *testing.T) {
 	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
 
-	req := httptest.NewRequest(http.MethodGet, "/create", nil)
+	req := authedRequest(t, http.MethodGet, "/create", nil, userID)
 	w := httptest.NewRecorder()
 
-	handleCreate(w, req)
+	handleCreate(w, req, userID)
 
 	if w.Code != http.StatusSeeOther {
 		t.Errorf("GET /create status = %d, want %d", w.Code, http.StatusSeeOther)
@@ -239,15 +334,419 @@ func TestHandleCreateGetRedirects(t *testing.T) {
 
 func TestHandleToggleInvalidID(t *testing.T) {
 	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
 
 	form := url.Values{"id": {"notanumber"}}
-	req := httptest.NewRequest(http.MethodPost, "/toggle", strings.NewReader(form.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req := authedRequest(t, http.MethodPost, "/toggle", strings.NewReader(form.Encode()), userID)
 	w := httptest.NewRecorder()
 
-	handleToggle(w, req)
+	handleToggle(w, req, userID)
 
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
 	}
 }
+
+func TestHandleCreateHXRequestReturnsFragment(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+
+	form := url.Values{"title": {"Fragment me"}}
+	req := authedRequest(t, http.MethodPost, "/create", strings.NewReader(form.Encode()), userID)
+	req.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+
+	handleCreate(w, req, userID)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), "Fragment me") {
+		t.Errorf("body = %q, want it to contain todo title", w.Body.String())
+	}
+	if strings.Contains(w.Header().Get("Content-Type"), "text/html") == false {
+		t.Errorf("Content-Type = %q, want text/html", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestHandleToggleHXRequestReturnsFragment(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+	id, _ := createTodo(userID, "Toggle me")
+
+	form := url.Values{"id": {strconv.FormatInt(id, 10)}}
+	req := authedRequest(t, http.MethodPost, "/toggle", strings.NewReader(form.Encode()), userID)
+	req.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+
+	handleToggle(w, req, userID)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), "checked") {
+		t.Errorf("body = %q, want checked checkbox", w.Body.String())
+	}
+}
+
+func TestHandleDeleteHXRequestReturnsEmptyBodyWithTrigger(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+	id, _ := createTodo(userID, "Delete me")
+
+	form := url.Values{"id": {strconv.FormatInt(id, 10)}}
+	req := authedRequest(t, http.MethodPost, "/delete", strings.NewReader(form.Encode()), userID)
+	req.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+
+	handleDelete(w, req, userID)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %q, want empty", w.Body.String())
+	}
+	if got := w.Header().Get("HX-Trigger"); got != "todoDeleted" {
+		t.Errorf("HX-Trigger = %q, want %q", got, "todoDeleted")
+	}
+}
+
+func TestHandleToggleCrossUserIsolation(t *testing.T) {
+	setupTestDB(t)
+	owner := setupTestUser(t, "owner@example.com")
+	attacker := setupTestUser(t, "attacker@example.com")
+	id, _ := createTodo(owner, "Owner's todo")
+
+	form := url.Values{"id": {strconv.FormatInt(id, 10)}}
+	req := authedRequest(t, http.MethodPost, "/toggle", strings.NewReader(form.Encode()), attacker)
+	w := httptest.NewRecorder()
+
+	handleToggle(w, req, attacker)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	got, _ := todos.get(id)
+	if got.Completed {
+		t.Errorf("completed = %v, want false (untouched by attacker)", got.Completed)
+	}
+}
+
+func TestHandleDeleteCrossUserIsolation(t *testing.T) {
+	setupTestDB(t)
+	owner := setupTestUser(t, "owner@example.com")
+	attacker := setupTestUser(t, "attacker@example.com")
+	id, _ := createTodo(owner, "Owner's todo")
+
+	form := url.Values{"id": {strconv.FormatInt(id, 10)}}
+	req := authedRequest(t, http.MethodPost, "/delete", strings.NewReader(form.Encode()), attacker)
+	w := httptest.NewRecorder()
+
+	handleDelete(w, req, attacker)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if _, ok := todos.get(id); !ok {
+		t.Errorf("todo %d should survive an attacker's delete", id)
+	}
+}
+
+func TestRequireAuthRejectsMissingSession(t *testing.T) {
+	setupTestDB(t)
+
+	handler := requireAuth(func(w http.ResponseWriter, r *http.Request, userID int64) {
+		t.Fatal("handler should not run without a valid session")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireAuthAcceptsValidSession(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+	u, err := userByEmailAndTokenForID(userID)
+	if err != nil {
+		t.Fatalf("looking up user: %v", err)
+	}
+
+	var gotUserID int64
+	handler := requireAuth(func(w http.ResponseWriter, r *http.Request, userID int64) {
+		gotUserID = userID
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: u.Token})
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if gotUserID != userID {
+		t.Errorf("userID = %d, want %d", gotUserID, userID)
+	}
+}
+
+func TestCountIncompleteTodos(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+
+	createTodo(userID, "First")
+	secondID, _ := createTodo(userID, "Second")
+	createTodo(userID, "Third")
+	toggleTodo(userID, secondID)
+
+	count, err := countIncompleteTodos(userID)
+	if err != nil {
+		t.Fatalf("countIncompleteTodos: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("count = %d, want 2", count)
+	}
+}
+
+func TestParseOnlyFilter(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "all"},
+		{"only=all", "all"},
+		{"only=active", "active"},
+		{"only=completed", "completed"},
+		{"only=bogus", "all"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+		if got := parseOnlyFilter(req); got != tt.want {
+			t.Errorf("parseOnlyFilter(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestListTodosFiltersByOnly(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+
+	createTodo(userID, "Active one")
+	doneID, _ := createTodo(userID, "Done one")
+	toggleTodo(userID, doneID)
+
+	tests := []struct {
+		only    string
+		want    string
+		missing string
+	}{
+		{"active", "Active one", "Done one"},
+		{"completed", "Done one", "Active one"},
+	}
+	for _, tt := range tests {
+		lofigui.Reset()
+		listTodos(userID, tt.only)
+		got := lofigui.Buffer()
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("only=%s: body = %q, want it to contain %q", tt.only, got, tt.want)
+		}
+		if strings.Contains(got, tt.missing) {
+			t.Errorf("only=%s: body = %q, want it to exclude %q", tt.only, got, tt.missing)
+		}
+	}
+
+	lofigui.Reset()
+	listTodos(userID, "all")
+	all := lofigui.Buffer()
+	if !strings.Contains(all, "Active one") || !strings.Contains(all, "Done one") {
+		t.Errorf("only=all: body = %q, want both todos", all)
+	}
+}
+
+func TestHandleIndexFiltersByOnly(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+
+	createTodo(userID, "Active one")
+	doneID, _ := createTodo(userID, "Done one")
+	toggleTodo(userID, doneID)
+
+	handler := handleIndex(nil)
+
+	req := authedRequest(t, http.MethodGet, "/?only=active", nil, userID)
+	req.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+	handler(w, req, userID)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Active one") {
+		t.Errorf("only=active: body = %q, want it to contain %q", body, "Active one")
+	}
+	if strings.Contains(body, "Done one") {
+		t.Errorf("only=active: body = %q, want it to exclude %q", body, "Done one")
+	}
+
+	req = authedRequest(t, http.MethodGet, "/?only=completed", nil, userID)
+	req.Header.Set("HX-Request", "true")
+	w = httptest.NewRecorder()
+	handler(w, req, userID)
+
+	body = w.Body.String()
+	if !strings.Contains(body, "Done one") {
+		t.Errorf("only=completed: body = %q, want it to contain %q", body, "Done one")
+	}
+	if strings.Contains(body, "Active one") {
+		t.Errorf("only=completed: body = %q, want it to exclude %q", body, "Active one")
+	}
+}
+
+func TestHandleRegister(t *testing.T) {
+	setupTestDB(t)
+
+	form := url.Values{"email": {"new@example.com"}}
+	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	handleRegister(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var count int
+	db.QueryRow("SELECT count(*) FROM users WHERE email = $1", "new@example.com").Scan(&count)
+	if count != 1 {
+		t.Errorf("count = %d, want 1", count)
+	}
+}
+
+func TestHandleLoginSetsSessionCookie(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+	u, err := userByEmailAndTokenForID(userID)
+	if err != nil {
+		t.Fatalf("looking up user: %v", err)
+	}
+
+	form := url.Values{"email": {u.Email}, "token": {u.Token}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	handleLogin(w, req)
+
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session" || cookies[0].Value != u.Token {
+		t.Errorf("cookies = %v, want a session cookie with the user's token", cookies)
+	}
+}
+
+func TestHandleLoginRejectsBadCredentials(t *testing.T) {
+	setupTestDB(t)
+	setupTestUser(t, "a@example.com")
+
+	form := url.Values{"email": {"a@example.com"}, "token": {"wrong"}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	handleLogin(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestHandleLogoutClearsSessionCookie(t *testing.T) {
+	setupTestDB(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	w := httptest.NewRecorder()
+
+	handleLogout(w, req)
+
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session" || cookies[0].MaxAge >= 0 {
+		t.Errorf("cookies = %v, want an expired session cookie", cookies)
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+	createTodo(userID, "First")
+	createTodo(userID, "Second")
+
+	req := httptest.NewRequest(http.MethodGet, "/events?since=0", nil)
+	w := httptest.NewRecorder()
+
+	handleEvents(w, req, userID)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "TodoCreated") || !strings.Contains(body, "First") || !strings.Contains(body, "Second") {
+		t.Errorf("body = %q, want both TodoCreated events", body)
+	}
+}
+
+func TestHandleEventsSince(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "a@example.com")
+	firstID, _ := createTodo(userID, "First")
+	createTodo(userID, "Second")
+
+	req := httptest.NewRequest(http.MethodGet, "/events?since=1", nil)
+	w := httptest.NewRecorder()
+
+	handleEvents(w, req, userID)
+
+	body := w.Body.String()
+	if strings.Contains(body, "First") {
+		t.Errorf("body = %q, should not contain the event already seen (id %d)", body, firstID)
+	}
+	if !strings.Contains(body, "Second") {
+		t.Errorf("body = %q, want the new event", body)
+	}
+}
+
+func TestHandleEventsCrossUserIsolation(t *testing.T) {
+	setupTestDB(t)
+	owner := setupTestUser(t, "owner@example.com")
+	other := setupTestUser(t, "other@example.com")
+
+	createTodo(owner, "Owner's todo")
+	createTodo(other, "Other's todo")
+
+	req := httptest.NewRequest(http.MethodGet, "/events?since=0", nil)
+	w := httptest.NewRecorder()
+
+	handleEvents(w, req, other)
+
+	body := w.Body.String()
+	if strings.Contains(body, "Owner's todo") {
+		t.Errorf("body = %q, should not contain another user's event", body)
+	}
+	if !strings.Contains(body, "Other's todo") {
+		t.Errorf("body = %q, want the requesting user's own event", body)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// TodoRepository is the single point of access to todo storage, shared by
+// the HTML handlers and the JSON API.
+type TodoRepository interface {
+	// Find returns the todo owned by userID, or sql.ErrNoRows if it
+	// doesn't exist or belongs to another user.
+	Find(userID, id int64) (Todo, error)
+	// FindList returns the todos owned by userID matching only ("all",
+	// "active", or "completed"), ordered by id.
+	FindList(userID int64, only string) ([]Todo, error)
+	// Save creates t if t.ID is zero, otherwise updates the existing
+	// todo's title and completed state. It returns sql.ErrNoRows if
+	// updating a todo that doesn't exist or belongs to another user.
+	Save(userID int64, t Todo) (Todo, error)
+	// Toggle flips the completed state of the todo owned by userID, or
+	// returns sql.ErrNoRows if it doesn't exist or belongs to another
+	// user.
+	Toggle(userID, id int64) error
+	// Destroy removes the todo owned by userID, or returns sql.ErrNoRows
+	// if it doesn't exist or belongs to another user.
+	Destroy(userID, id int64) error
+}
+
+// eventSourcedTodoRepository implements TodoRepository on top of the
+// append-only event log and its in-memory projection.
+type eventSourcedTodoRepository struct {
+	events *EventStore
+	todos  *todoProjection
+
+	// writeMu serializes the validate-append-apply sequence in Save's
+	// update branch, Toggle, and Destroy, so a racing pair of writers
+	// (e.g. a toggle and a delete for the same todo) can't interleave
+	// between the ownership check and the event append. Without it the
+	// log could record an event for a projection state that no longer
+	// matches what was validated, which breaks the invariant that the
+	// log is the literal record of what happened to the projection.
+	writeMu sync.Mutex
+}
+
+// NewTodoRepository returns the TodoRepository backed by store and
+// projection.
+func NewTodoRepository(store *EventStore, projection *todoProjection) TodoRepository {
+	return &eventSourcedTodoRepository{events: store, todos: projection}
+}
+
+func (repo *eventSourcedTodoRepository) Find(userID, id int64) (Todo, error) {
+	t, ok := repo.todos.get(id)
+	if !ok || t.UserID != userID {
+		return Todo{}, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func (repo *eventSourcedTodoRepository) FindList(userID int64, only string) ([]Todo, error) {
+	return repo.todos.list(userID, only), nil
+}
+
+func (repo *eventSourcedTodoRepository) Save(userID int64, t Todo) (Todo, error) {
+	if t.ID == 0 {
+		t.ID = repo.todos.allocateID()
+		t.UserID = userID
+		payload := TodoCreatedPayload{ID: t.ID, Title: t.Title, UserID: userID}
+		if _, err := repo.events.Append(userID, "TodoCreated", payload); err != nil {
+			return Todo{}, err
+		}
+		repo.todos.apply(t)
+		return t, nil
+	}
+
+	repo.writeMu.Lock()
+	defer repo.writeMu.Unlock()
+
+	existing, ok := repo.todos.get(t.ID)
+	if !ok || existing.UserID != userID {
+		return Todo{}, sql.ErrNoRows
+	}
+	t.UserID = userID
+	payload := TodoUpdatedPayload{ID: t.ID, Title: t.Title, Completed: t.Completed}
+	if _, err := repo.events.Append(userID, "TodoUpdated", payload); err != nil {
+		return Todo{}, err
+	}
+	repo.todos.apply(t)
+	return t, nil
+}
+
+func (repo *eventSourcedTodoRepository) Toggle(userID, id int64) error {
+	repo.writeMu.Lock()
+	defer repo.writeMu.Unlock()
+
+	t, ok := repo.todos.get(id)
+	if !ok || t.UserID != userID {
+		return sql.ErrNoRows
+	}
+	if _, err := repo.events.Append(userID, "TodoToggled", TodoToggledPayload{ID: id}); err != nil {
+		return err
+	}
+	repo.todos.toggle(id)
+	return nil
+}
+
+func (repo *eventSourcedTodoRepository) Destroy(userID, id int64) error {
+	repo.writeMu.Lock()
+	defer repo.writeMu.Unlock()
+
+	t, ok := repo.todos.get(id)
+	if !ok || t.UserID != userID {
+		return sql.ErrNoRows
+	}
+	if _, err := repo.events.Append(userID, "TodoDeleted", TodoDeletedPayload{ID: id}); err != nil {
+		return err
+	}
+	repo.todos.delete(id)
+	return nil
+}
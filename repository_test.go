@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+// TestToggleDeleteRace interleaves a Toggle and a Destroy on the same todo
+// to make sure the validate-append-apply sequence in each is atomic: the
+// event log must never record a TodoToggled for a todo that TodoDeleted
+// already removed.
+func TestToggleDeleteRace(t *testing.T) {
+	setupTestDB(t)
+	userID := setupTestUser(t, "racer@example.com")
+
+	for i := 0; i < 50; i++ {
+		created, err := todoRepo.Save(userID, Todo{Title: "race"})
+		if err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			todoRepo.Toggle(userID, created.ID)
+		}()
+		go func() {
+			defer wg.Done()
+			todoRepo.Destroy(userID, created.ID)
+		}()
+		wg.Wait()
+
+		evs, err := events.Since(userID, 0)
+		if err != nil {
+			t.Fatalf("Since: %v", err)
+		}
+
+		var toggledAfterDeleted bool
+		var deletedSeen bool
+		for _, e := range evs {
+			var id struct {
+				ID int64 `json:"id"`
+			}
+			if err := json.Unmarshal(e.Payload, &id); err != nil {
+				t.Fatalf("unmarshal payload: %v", err)
+			}
+			if id.ID != created.ID {
+				continue
+			}
+			switch e.Type {
+			case "TodoDeleted":
+				deletedSeen = true
+			case "TodoToggled":
+				if deletedSeen {
+					toggledAfterDeleted = true
+				}
+			}
+		}
+		if toggledAfterDeleted {
+			t.Fatalf("iteration %d: TodoToggled recorded after TodoDeleted for id %d", i, created.ID)
+		}
+	}
+}